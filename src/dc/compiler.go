@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Opcode identifies one dispatchable operation in a compiled Program.
+type Opcode int
+
+const (
+	OpPushNumber Opcode = iota
+	OpPushCommand
+	OpAdd
+	OpSub
+	OpDiv
+	OpMul
+	OpMod
+	OpPow
+	OpSqrt
+	OpStore
+	OpStorePush
+	OpLoad
+	OpLoadTop
+	OpDup
+	OpPrint
+	OpPrintPop
+	OpPrintAll
+	OpQuit
+	OpQuitN
+	OpExec
+	OpExecScale
+	OpLessThan
+	OpGreaterThan
+	OpEqual
+	OpShellExec
+	OpClearStack
+	OpSetScale
+	OpPushScale
+	OpSetBase
+	OpPushBase
+	OpSetOutScale
+	OpDepth
+	OpLen
+	OpReadExec
+)
+
+// Instruction is a single compiled opcode plus whichever immediate it
+// needs: a number literal, a register rune, or the index of a compiled
+// sub-program.
+type Instruction struct {
+	Op       Opcode
+	Number   ScaledNumber
+	Register rune
+	Program  int
+}
+
+// Program is a dc source string compiled once into a flat instruction
+// list. Bracketed commands compile into SubPrograms rather than being
+// re-lexed every time they're invoked.
+type Program struct {
+	Instructions []Instruction
+	SubPrograms  []*Program
+	Source       string
+}
+
+// Compile lexes and parses a dc source string into a Program ready for
+// the Interp dispatch loop. Bracketed commands ([...]) are compiled
+// recursively into SubPrograms, so Interp never re-parses the same text
+// twice.
+func Compile(source string) (*Program, error) {
+	prog := &Program{}
+	reader := bufio.NewReader(strings.NewReader(source))
+
+	for {
+		next, _, readErr := reader.ReadRune()
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			return nil, readErr
+		}
+
+		if unicode.In(next, unicode.Digit) || next == '_' || next == '.' {
+			if err := reader.UnreadRune(); err != nil {
+				return nil, err
+			}
+			item, err := ReadNumber(reader)
+			if err != nil {
+				return nil, err
+			}
+			prog.Instructions = append(prog.Instructions, Instruction{Op: OpPushNumber, Number: item.Number})
+			continue
+		}
+
+		if next == '[' {
+			if err := reader.UnreadRune(); err != nil {
+				return nil, err
+			}
+			source, err := ReadCommand(reader)
+			if err != nil {
+				return nil, err
+			}
+			child, err := Compile(string(source))
+			if err != nil {
+				return nil, err
+			}
+			child.Source = string(source)
+			prog.SubPrograms = append(prog.SubPrograms, child)
+			prog.Instructions = append(prog.Instructions, Instruction{Op: OpPushCommand, Program: len(prog.SubPrograms) - 1})
+			continue
+		}
+
+		var instr Instruction
+		switch next {
+		case '+':
+			instr.Op = OpAdd
+		case '-':
+			instr.Op = OpSub
+		case '/':
+			instr.Op = OpDiv
+		case '*':
+			instr.Op = OpMul
+		case '%':
+			instr.Op = OpMod
+		case '^':
+			instr.Op = OpPow
+		case 'v':
+			instr.Op = OpSqrt
+		case 's':
+			instr.Op = OpStore
+		case 'S':
+			instr.Op = OpStorePush
+		case 'l':
+			instr.Op = OpLoad
+		case 'L':
+			instr.Op = OpLoadTop
+		case 'd':
+			instr.Op = OpDup
+		case 'p':
+			instr.Op = OpPrint
+		case 'P':
+			instr.Op = OpPrintPop
+		case 'f':
+			instr.Op = OpPrintAll
+		case 'q':
+			instr.Op = OpQuit
+		case 'Q':
+			instr.Op = OpQuitN
+		case 'x':
+			instr.Op = OpExec
+		case 'X':
+			instr.Op = OpExecScale
+		case '<':
+			instr.Op = OpLessThan
+		case '>':
+			instr.Op = OpGreaterThan
+		case '=':
+			instr.Op = OpEqual
+		case '!':
+			instr.Op = OpShellExec
+		case 'c':
+			instr.Op = OpClearStack
+		case 'i':
+			instr.Op = OpSetScale
+		case 'I':
+			instr.Op = OpPushScale
+		case 'o':
+			instr.Op = OpSetBase
+		case 'O':
+			instr.Op = OpPushBase
+		case 'k':
+			instr.Op = OpSetOutScale
+		case 'z':
+			instr.Op = OpDepth
+		case 'Z':
+			instr.Op = OpLen
+		case '?':
+			instr.Op = OpReadExec
+		default:
+			// Whitespace and any other unrecognised rune is simply
+			// skipped, matching the original rune-at-a-time interpreter.
+			continue
+		}
+
+		switch instr.Op {
+		case OpStore, OpStorePush, OpLoad, OpLoadTop, OpLessThan, OpGreaterThan, OpEqual:
+			registerName, _, readErr := reader.ReadRune()
+			if readErr != nil {
+				return nil, readErr
+			}
+			instr.Register = registerName
+		}
+
+		prog.Instructions = append(prog.Instructions, instr)
+	}
+
+	return prog, nil
+}
+
+// Run compiles all of r into a Program and executes it against the
+// current interpreter state.
+func Run(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	prog, err := Compile(string(data))
+	if err != nil {
+		return err
+	}
+
+	return Interp(prog)
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"os"
 	"strconv"
 	"strings"
@@ -12,7 +14,7 @@ import (
 )
 
 type ScaledNumber struct {
-	Number int64
+	Number *big.Int
 	Scale  int32
 }
 
@@ -21,7 +23,7 @@ type CommandLine string
 type StackItem struct {
 	IsNumber bool
 	Number   ScaledNumber
-	Command  CommandLine
+	Command  *Program
 }
 
 type Stack struct {
@@ -36,21 +38,90 @@ const DebugLog bool = false
 var RootStack *Stack
 var Registers map[rune]*Stack
 var InterpScale int32 = 0
-var InterpLevel int32 = 0
 var OutBase int64 = 10
 var OutScale int32 = 0
 var MemLog []string
 var MemLogP int = 0
 
+// Frame is one entry in InvocationStack: the compiled program a macro
+// invocation is executing, how far through it we are, and the register
+// it was reached through (0 for the top-level program or a plain `x`).
+type Frame struct {
+	Program  *Program
+	PC       int
+	Register rune
+}
+
+// InvocationStack replaces the Go call stack that previously backed
+// nested `x`, `<r`, `>r`, `=r` invocations, so that `q`/`Q` can unwind a
+// specific number of macro levels regardless of how they were entered.
+var InvocationStack []Frame
+
+// MaxInvocationStackSize bounds how many nested macro invocations are
+// allowed, modeled on Neo VM's 1024-frame cap.
+const MaxInvocationStackSize int = 1024
+
+// MaxStackSize bounds the total number of items held across RootStack
+// and every register stack combined.
+const MaxStackSize int = 100000
+
+// MaxTryDepth bounds how many Try calls may nest, so a macro that
+// recursively wraps itself in try/catch can't exhaust the Go call stack.
+const MaxTryDepth int = 32
+
+var tryDepth int
+
+// StackOverflow is returned when MaxStackSize or MaxInvocationStackSize
+// would be exceeded.
+type StackOverflow struct {
+	Limit int
+}
+
+func (e *StackOverflow) Error() string {
+	return fmt.Sprintf("stack depth exceeded maximum of %d", e.Limit)
+}
+
+// StackUnderflow is returned when an operation needs a register stack
+// that has never been populated.
+type StackUnderflow struct {
+	Register rune
+}
+
+func (e *StackUnderflow) Error() string {
+	return fmt.Sprintf("register '%c' is empty", e.Register)
+}
+
+// NegativeSquareRoot is returned when v is asked for the square root of
+// a negative number.
+type NegativeSquareRoot struct{}
+
+func (e *NegativeSquareRoot) Error() string {
+	return "square root of a negative number"
+}
+
+// DivideByZero is returned by / and % instead of letting big.Int panic
+// on a zero divisor.
+type DivideByZero struct {
+	Op rune
+}
+
+func (e *DivideByZero) Error() string {
+	return fmt.Sprintf("%c: divide by zero", e.Op)
+}
+
 // Record logging messages into a rotating memory buffer. We can potentially
 // print these out by setting DebugLog to true, or if we need to GDB in, we can
 // look at the contents of MemLog. Shouldn't affect our run time *too* much
 func Log(msg string, args ...interface{}) {
-	MemLog[MemLogP] = fmt.Sprintf(msg, args...)
+	formatted := fmt.Sprintf(msg, args...)
+	MemLog[MemLogP] = formatted
 	MemLogP += 1
 	if MemLogP >= MemLogLen {
 		MemLogP = 0
 	}
+	if ActiveDebugger != nil {
+		ActiveDebugger.OnLog(formatted)
+	}
 }
 
 // Print out the log messages. If the buffer has rotated past MemLogLen, we
@@ -68,7 +139,7 @@ func NewItem() (i *StackItem) {
 	Log("creating new stack item")
 	i = new(StackItem)
 	i.IsNumber = true
-	i.Number = ScaledNumber{Number: 0, Scale: 0}
+	i.Number = ScaledNumber{Number: big.NewInt(0), Scale: 0}
 	return i
 }
 
@@ -114,9 +185,9 @@ func (s *Stack) Pop() (i *StackItem) {
 
 // Return a copy of the item from the top of the stack
 func (s *Stack) Peek() (i *StackItem) {
-	j = s.Items[s.Pointer]
+	j := s.Items[s.Pointer]
 
-	i := NewItem()
+	i = NewItem()
 	i.IsNumber = j.IsNumber
 	i.Number = j.Number
 	i.Command = j.Command
@@ -125,24 +196,28 @@ func (s *Stack) Peek() (i *StackItem) {
 	return
 }
 
-func IntPower(n int64, y int32) int64 {
-	m := n
-	if y > 0 {
-		for i:=1; i < y; i++ {
-			m *= n
-		}
-	} else {
-		for i:=0; i >= y; i-- {
-			m /= n
-		}
+// IntPower returns n raised to the y-th power as an arbitrary-precision
+// integer. Negative exponents return the same magnitude as their positive
+// counterpart; callers that need to scale down divide by the result instead
+// of asking for a negative exponent directly.
+func IntPower(n *big.Int, y int32) *big.Int {
+	if y < 0 {
+		y = -y
 	}
-	return m
+	return new(big.Int).Exp(n, big.NewInt(int64(y)), nil)
 }
 
 // Maintains integer values without while scaling the number up or down.
 func RescaleNumber(n *ScaledNumber, newScale int32) {
 	Log("rescaling number: n=%v, s=%v", n, newScale)
-	n.Number = n.Number * intPower(10, newScale-n.Scale)
+	diff := newScale - n.Scale
+	factor := IntPower(big.NewInt(10), diff)
+	if diff >= 0 {
+		n.Number = new(big.Int).Mul(n.Number, factor)
+	} else {
+		n.Number = new(big.Int).Quo(n.Number, factor)
+	}
+	n.Scale = newScale
 }
 
 func IntMax(na ...int32) int32 {
@@ -173,13 +248,138 @@ func IntAbs(n int32) int32 {
 	}
 }
 
+// IntSqrt returns the floor of n's square root using integer Newton's
+// method: starting from a bit-length estimate, iterate
+// x_{k+1} = (x_k + n/x_k) / 2 until it stops decreasing. n is assumed
+// non-negative; callers reject negative input themselves.
+func IntSqrt(n *big.Int) *big.Int {
+	if n.Sign() == 0 {
+		return big.NewInt(0)
+	}
+
+	x := new(big.Int).Lsh(big.NewInt(1), uint((n.BitLen()+1)/2))
+	two := big.NewInt(2)
+	for {
+		next := new(big.Int).Quo(n, x)
+		next.Add(next, x)
+		next.Quo(next, two)
+		if next.Cmp(x) >= 0 {
+			// Also correct when n's mantissa is exactly 1: x starts at
+			// 2, the first iteration already settles on x=1.
+			return x
+		}
+		x = next
+	}
+}
+
+// digitChar renders a single base-OutBase digit (d < 16) the way dc does
+// for obase <= 16: '0'-'9', then uppercase 'A'-'F'.
+func digitChar(d int64) byte {
+	if d < 10 {
+		return byte('0' + d)
+	}
+	return byte('A' + d - 10)
+}
+
+// baseDigits returns v's digits in base b, most significant first. v must
+// be non-negative; zero renders as a single 0 digit.
+func baseDigits(v *big.Int, b int64) []int64 {
+	if v.Sign() == 0 {
+		return []int64{0}
+	}
+
+	base := big.NewInt(b)
+	rem := new(big.Int)
+	q := new(big.Int).Set(v)
+	var digits []int64
+	for q.Sign() > 0 {
+		q.QuoRem(q, base, rem)
+		digits = append(digits, rem.Int64())
+	}
+
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return digits
+}
+
+// joinDigits renders digits as dc would for the given base: packed
+// hex-like characters when the base fits in one char (<=16), otherwise
+// decimal groups zero-padded to the width of base-1 and separated by a
+// space, since a single digit can exceed 'Z'. The caller is responsible
+// for the leading space dc puts before the first group of a >16 base
+// number, since that belongs once per rendered number, not once per
+// joinDigits call.
+func joinDigits(digits []int64, base int64) string {
+	if base <= 16 {
+		buf := make([]byte, len(digits))
+		for i, d := range digits {
+			buf[i] = digitChar(d)
+		}
+		return string(buf)
+	}
+
+	width := len(strconv.FormatInt(base-1, 10))
+	groups := make([]string, len(digits))
+	for i, d := range digits {
+		groups[i] = fmt.Sprintf("%0*d", width, d)
+	}
+	return strings.Join(groups, " ")
+}
+
+// formatScaledNumber renders n in OutBase, converting both its integer
+// and fractional parts into that base rather than just reusing n's
+// decimal digit string. n.Scale always counts decimal fractional digits
+// (POSIX dc's scale is base-10 regardless of obase), so the fraction is
+// recovered by scaling it up into OutBase^Scale before converting. When
+// OutScale is non-zero it overrides n.Scale as the number of fractional
+// digits shown, padding or truncating the fraction to match.
+func formatScaledNumber(n ScaledNumber) string {
+	neg := n.Number.Sign() < 0
+	abs := new(big.Int).Abs(n.Number)
+
+	displayScale := n.Scale
+	if OutScale != 0 {
+		displayScale = OutScale
+	}
+
+	var text string
+	if displayScale == 0 {
+		text = joinDigits(baseDigits(abs, OutBase), OutBase)
+	} else {
+		pow10 := IntPower(big.NewInt(10), n.Scale)
+		intPart := new(big.Int)
+		fracPart := new(big.Int)
+		intPart.QuoRem(abs, pow10, fracPart)
+
+		obasePow := IntPower(big.NewInt(OutBase), displayScale)
+		scaledFrac := new(big.Int).Mul(fracPart, obasePow)
+		scaledFrac.Quo(scaledFrac, pow10)
+
+		fracDigits := baseDigits(scaledFrac, OutBase)
+		for int32(len(fracDigits)) < displayScale {
+			fracDigits = append([]int64{0}, fracDigits...)
+		}
+
+		text = joinDigits(baseDigits(intPart, OutBase), OutBase) + "." + joinDigits(fracDigits, OutBase)
+	}
+
+	if OutBase > 16 {
+		text = " " + text
+	}
+	if neg {
+		text = "-" + text
+	}
+	return text
+}
+
 func ReadNumber(reader *bufio.Reader) (item *StackItem, err error) {
 	item = NewItem()
 
 	var next rune
 	var numStr string = ""
 	var scale int32 = 0
-	var base int = 10
+	var seenDot bool = false
 	var eofReached bool = false
 
 	for {
@@ -198,15 +398,12 @@ func ReadNumber(reader *bufio.Reader) (item *StackItem, err error) {
 		}
 
 		if next == '.' {
-			scale += 1
+			seenDot = true
 			continue
 		}
 
-		if unicode.In(next, unicode.Hex_Digit) {
-			if !unicode.In(next, unicode.Digit) {
-				base = 16
-			}
-			if scale > 0 {
+		if unicode.In(next, unicode.Digit) {
+			if seenDot {
 				scale += 1
 			}
 			numStr += string(next)
@@ -223,11 +420,18 @@ func ReadNumber(reader *bufio.Reader) (item *StackItem, err error) {
 		}
 	}
 
-	item.Number.Number, err = strconv.ParseInt(numStr, base, 64)
-	if err != nil {
+	number, ok := new(big.Int).SetString(numStr, 10)
+	if !ok {
+		err = fmt.Errorf("invalid number literal: %q", numStr)
 		return
 	}
 
+	// A literal ending in EOF is still a complete, valid number: clear
+	// the io.EOF picked up by the read loop so it isn't reported as a
+	// failure of this read.
+	err = nil
+
+	item.Number.Number = number
 	item.Number.Scale = scale
 	item.IsNumber = true
 
@@ -236,9 +440,7 @@ func ReadNumber(reader *bufio.Reader) (item *StackItem, err error) {
 	return
 }
 
-func ReadCommand(reader *bufio.Reader) (item *StackItem, err error) {
-	item = NewItem()
-
+func ReadCommand(reader *bufio.Reader) (source CommandLine, err error) {
 	var next rune
 	var command string = ""
 	var startFound bool = false
@@ -278,56 +480,124 @@ func ReadCommand(reader *bufio.Reader) (item *StackItem, err error) {
 	// Since the last character was the ']' and belongs to this command, we
 	// don't need to push anything back onto the reader like we do with the ReadNumber
 
-	item.IsNumber = false
-	item.Command = CommandLine(command)
+	source = CommandLine(command)
 
-	Log("read command: %v", item)
+	Log("read command: %v", source)
 
 	return
 
 }
 
-// Base interpreter which reads through the input stream & executes the
-// provided commands
-func Interp(r io.Reader) error {
-	reader := bufio.NewReader(r)
+// totalStackItems sums the items held on RootStack and every register
+// stack, for comparison against MaxStackSize.
+func totalStackItems() int {
+	total := RootStack.Pointer + 1
+	for _, r := range Registers {
+		total += r.Pointer + 1
+	}
+	return total
+}
 
-	for {
-		next, _, readErr := reader.ReadRune()
-		Log("read next: %c", next)
-		if readErr != nil {
-			if readErr == io.EOF {
-				break
-			}
-			return readErr
+// pushFrame enters prog as a new invocation, failing with a StackOverflow
+// instead of growing InvocationStack past MaxInvocationStackSize.
+func pushFrame(prog *Program, register rune) error {
+	if len(InvocationStack) >= MaxInvocationStackSize {
+		return &StackOverflow{Limit: MaxInvocationStackSize}
+	}
+	InvocationStack = append(InvocationStack, Frame{Program: prog, Register: register})
+	return nil
+}
+
+// popFrames drops up to n frames from the top of InvocationStack, never
+// reaching below base. This is what gives q/Q uniform semantics whether
+// the enclosing invocation came from x or a conditional.
+func popFrames(base, n int) {
+	depth := len(InvocationStack) - base
+	if n > depth {
+		n = depth
+	}
+	if n < 0 {
+		n = 0
+	}
+	InvocationStack = InvocationStack[:len(InvocationStack)-n]
+}
+
+// Try runs prog and recovers from any panic (for example, dividing by
+// zero), converting it into an error. This lets a caller embedding this
+// interpreter survive a bad macro instead of tearing down the process.
+func Try(prog *Program) (err error) {
+	if tryDepth >= MaxTryDepth {
+		return fmt.Errorf("try: max nesting depth of %d exceeded", MaxTryDepth)
+	}
+
+	tryDepth++
+	defer func() {
+		tryDepth--
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic: %v", r)
 		}
+	}()
 
-		if unicode.In(next, unicode.Digit) || next == '_' || next == '.' {
-			if err := reader.UnreadRune(); err != nil {
-				return err
-			}
-			if number, err := ReadNumber(reader); err != nil {
-				return err
-			} else {
-				RootStack.Push(number)
-				continue
-			}
+	return Interp(prog)
+}
+
+// Interp dispatches over a compiled Program's instructions, mirroring a
+// classic opcode VM loop. Source text is parsed once by Compile; this
+// loop never re-lexes a macro body, however many times it's invoked.
+// Nested invocations (`x`, `<r`, `>r`, `=r`) push onto InvocationStack
+// rather than recursing, so q/Q can pop a specific number of them.
+func Interp(prog *Program) error {
+	base := len(InvocationStack)
+	defer func() {
+		if len(InvocationStack) > base {
+			InvocationStack = InvocationStack[:base]
 		}
+	}()
 
-		if next == '[' {
-			if err := reader.UnreadRune(); err != nil {
-				return err
-			}
-			if command, err := ReadCommand(reader); err != nil {
-				return err
-			} else {
-				RootStack.Push(command)
-				continue
+	if err := pushFrame(prog, 0); err != nil {
+		return err
+	}
+
+	for len(InvocationStack) > base {
+		if totalStackItems() > MaxStackSize {
+			return &StackOverflow{Limit: MaxStackSize}
+		}
+
+		frame := &InvocationStack[len(InvocationStack)-1]
+		if frame.PC >= len(frame.Program.Instructions) {
+			InvocationStack = InvocationStack[:len(InvocationStack)-1]
+			continue
+		}
+
+		pc := frame.PC
+		instr := frame.Program.Instructions[pc]
+		frame.PC++
+		Log("executing opcode: %v", instr.Op)
+
+		if ActiveDebugger != nil {
+			ActiveDebugger.SetProgram(frame.Program)
+			if armedAt(ActiveDebugger.BreakPoints(), pc) {
+				if !ActiveDebugger.BreakHook(pc, instr.Op, RootStack, Registers) {
+					return nil
+				}
+			} else if !ActiveDebugger.StepHook(pc, instr.Op, RootStack, Registers) {
+				return nil
 			}
 		}
 
-		switch next {
-		case '+':
+		switch instr.Op {
+		case OpPushNumber:
+			item := NewItem()
+			item.Number = instr.Number
+			RootStack.Push(item)
+
+		case OpPushCommand:
+			item := NewItem()
+			item.IsNumber = false
+			item.Command = frame.Program.SubPrograms[instr.Program]
+			RootStack.Push(item)
+
+		case OpAdd:
 			a := RootStack.Pop()
 			b := RootStack.Pop()
 			if !a.IsNumber || !b.IsNumber {
@@ -336,21 +606,16 @@ func Interp(r io.Reader) error {
 			aNum := a.Number
 			bNum := b.Number
 
-			targetScale := InterpScale
-			if aNum.Scale > bNum.Scale {
-				targetScale = aNum.Scale
-			} else {
-				targetScale = bNum.Scale
-			}
+			targetScale := IntMax(aNum.Scale, bNum.Scale)
 			RescaleNumber(&aNum, targetScale)
 			RescaleNumber(&bNum, targetScale)
 
 			c := NewItem()
 			c.IsNumber = true
-			c.Number = ScaledNumber{Scale: targetScale, Number: aNum.Number + bNum.Number}
+			c.Number = ScaledNumber{Scale: targetScale, Number: new(big.Int).Add(aNum.Number, bNum.Number)}
 			RootStack.Push(c)
 
-		case '-':
+		case OpSub:
 			a := RootStack.Pop()
 			b := RootStack.Pop()
 			if !a.IsNumber || !b.IsNumber {
@@ -359,21 +624,18 @@ func Interp(r io.Reader) error {
 			aNum := a.Number
 			bNum := b.Number
 
-			targetScale := InterpScale
-			if aNum.Scale > bNum.Scale {
-				targetScale = aNum.Scale
-			} else {
-				targetScale = bNum.Scale
-			}
+			targetScale := IntMax(aNum.Scale, bNum.Scale)
 			RescaleNumber(&aNum, targetScale)
 			RescaleNumber(&bNum, targetScale)
 
+			// dc's `-` is second-minus-top: a is the top of the stack, b
+			// the item underneath it.
 			c := NewItem()
 			c.IsNumber = true
-			c.Number = ScaledNumber{Scale: targetScale, Number: aNum.Number - bNum.Number}
+			c.Number = ScaledNumber{Scale: targetScale, Number: new(big.Int).Sub(bNum.Number, aNum.Number)}
 			RootStack.Push(c)
 
-		case '/':
+		case OpDiv:
 			a := RootStack.Pop()
 			b := RootStack.Pop()
 			if !a.IsNumber || !b.IsNumber {
@@ -382,15 +644,24 @@ func Interp(r io.Reader) error {
 			aNum := a.Number
 			bNum := b.Number
 
-			RescaleNumber(&aNum, InterpScale)
-			RescaleNumber(&bNum, InterpScale)
+			if aNum.Number.Sign() == 0 {
+				return &DivideByZero{Op: '/'}
+			}
+
+			// dc's `/` is second-divided-by-top, with InterpScale digits
+			// of fractional precision in the quotient. Rescaling both
+			// operands to InterpScale first (as division by the divisor's
+			// own scale would) cancels the scale entirely; instead scale
+			// the dividend up by InterpScale relative to the divisor and
+			// leave the divisor's scale alone.
+			RescaleNumber(&bNum, aNum.Scale+InterpScale)
 
 			c := NewItem()
 			c.IsNumber = true
-			c.Number = ScaledNumber{Scale: InterpScale, Number: aNum.Number / bNum.Number}
+			c.Number = ScaledNumber{Scale: InterpScale, Number: new(big.Int).Quo(bNum.Number, aNum.Number)}
 			RootStack.Push(c)
 
-		case '*':
+		case OpMul:
 			a := RootStack.Pop()
 			b := RootStack.Pop()
 			if !a.IsNumber || !b.IsNumber {
@@ -399,17 +670,19 @@ func Interp(r io.Reader) error {
 			aNum := a.Number
 			bNum := b.Number
 
+			// Multiply the raw mantissas first: their product already
+			// carries scale a.Scale+b.Scale, so rescaling each operand up
+			// to targetScale beforehand would apply that scale twice.
 			targetScale := IntMin(aNum.Scale+bNum.Scale, IntMax(InterpScale, aNum.Scale, bNum.Scale))
-
-			RescaleNumber(&aNum, targetScale)
-			RescaleNumber(&bNum, targetScale)
+			product := ScaledNumber{Scale: aNum.Scale + bNum.Scale, Number: new(big.Int).Mul(aNum.Number, bNum.Number)}
+			RescaleNumber(&product, targetScale)
 
 			c := NewItem()
 			c.IsNumber = true
-			c.Number = ScaledNumber{Scale: targetScale, Number: aNum.Number * bNum.Number}
+			c.Number = product
 			RootStack.Push(c)
 
-		case '%':
+		case OpMod:
 			a := RootStack.Pop()
 			b := RootStack.Pop()
 			if !a.IsNumber || !b.IsNumber {
@@ -418,93 +691,99 @@ func Interp(r io.Reader) error {
 			aNum := a.Number
 			bNum := b.Number
 
+			if aNum.Number.Sign() == 0 {
+				return &DivideByZero{Op: '%'}
+			}
+
 			targetScale := IntMin(aNum.Scale+bNum.Scale, IntMax(InterpScale, aNum.Scale, bNum.Scale))
 
 			RescaleNumber(&aNum, targetScale)
 			RescaleNumber(&bNum, targetScale)
 
+			// dc's `%` is second-modulo-top.
 			c := NewItem()
 			c.IsNumber = true
-			c.Number = ScaledNumber{Scale: targetScale, Number: aNum.Number % bNum.Number}
+			c.Number = ScaledNumber{Scale: targetScale, Number: new(big.Int).Rem(bNum.Number, aNum.Number)}
 			RootStack.Push(c)
 
-		case '^':
-			a := RootStack.Pop()
-			b := RootStack.Pop()
-			if !a.IsNumber || !b.IsNumber {
+		case OpPow:
+			// dc's `^` takes the exponent off the top of the stack and
+			// the base underneath it: `2 3^` is 2^3, not 3^2.
+			expItem := RootStack.Pop()
+			baseItem := RootStack.Pop()
+			if !expItem.IsNumber || !baseItem.IsNumber {
 				return fmt.Errorf("Expected both items from the stack to be numbers")
 			}
-			aNum := a.Number
-			bNum := b.Number
+			baseNum := baseItem.Number
+			expNum := expItem.Number
 
-			targetScale := IntMin(aNum.Scale*IntAbs(bNum.Scale), IntMax(InterpScale, aNum.Scale))
+			targetScale := IntMin(baseNum.Scale*IntAbs(expNum.Scale), IntMax(InterpScale, baseNum.Scale))
 
-			RescaleNumber(&aNum, targetScale)
-			RescaleNumber(&bNum, 0)
+			RescaleNumber(&baseNum, targetScale)
+			RescaleNumber(&expNum, 0)
 
-			powNum := aNum
-			if bNum.Number >= 0 {
-				for i := int64(0); i < bNum.Number; i++ {
-					powNum.Number = powNum.Number * aNum.Number
+			scaleFactor := IntPower(big.NewInt(10), targetScale)
+			exponent := expNum.Number.Int64()
+			powNum := new(big.Int).Set(scaleFactor)
+			if exponent >= 0 {
+				for i := int64(0); i < exponent; i++ {
+					powNum.Mul(powNum, baseNum.Number)
+					powNum.Quo(powNum, scaleFactor)
 				}
 			} else {
-				for i := int32(0); i < IntAbs(int32(bNum.Number)); i++ {
-					powNum.Number = powNum.Number / aNum.Number
+				for i := int64(0); i > exponent; i-- {
+					powNum.Mul(powNum, scaleFactor)
+					powNum.Quo(powNum, baseNum.Number)
 				}
 			}
 
 			c := NewItem()
 			c.IsNumber = true
-			c.Number = ScaledNumber{Scale: targetScale, Number: powNum.Number}
+			c.Number = ScaledNumber{Scale: targetScale, Number: powNum}
 			RootStack.Push(c)
 
-		case 'v':
-			// Square Root
-		case 's':
-			// Store in register
-			registerName, _, readErr := reader.ReadRune()
-			Log("register name: %c", registerName)
-			if readErr != nil {
-				return readErr
+		case OpSqrt:
+			a := RootStack.Pop()
+			if !a.IsNumber {
+				return fmt.Errorf("v can not be implemented with a command stack item")
 			}
+			if a.Number.Number.Sign() < 0 {
+				return &NegativeSquareRoot{}
+			}
+
+			resultScale := IntMax(a.Number.Scale, InterpScale)
+			aNum := a.Number
+			RescaleNumber(&aNum, 2*resultScale)
 
-			registerStack, Ok := Registers[registerName]
+			c := NewItem()
+			c.IsNumber = true
+			c.Number = ScaledNumber{Scale: resultScale, Number: IntSqrt(aNum.Number)}
+			RootStack.Push(c)
+
+		case OpStore:
+			registerStack, Ok := Registers[instr.Register]
 			if !Ok {
 				registerStack = NewStack()
-				Registers[registerName] = registerStack
+				Registers[instr.Register] = registerStack
 			}
 
 			registerStack.Pointer = 0
 			registerStack.Push(RootStack.Pop())
 
-		case 'S':
-			// Push in register
-			registerName, _, readErr := reader.ReadRune()
-			Log("register name: %c", registerName)
-			if readErr != nil {
-				return readErr
-			}
-
-			registerStack, Ok := Registers[registerName]
+		case OpStorePush:
+			registerStack, Ok := Registers[instr.Register]
 			if !Ok {
 				registerStack = NewStack()
-				Registers[registerName] = registerStack
+				Registers[instr.Register] = registerStack
 			}
 
 			registerStack.Push(RootStack.Pop())
 
-		case 'l':
-			// Retrieve from register
-			registerName, _, readErr := reader.ReadRune()
-			Log("register name: %c", registerName)
-			if readErr != nil {
-				return readErr
-			}
-
-			registerStack, Ok := Registers[registerName]
+		case OpLoad:
+			registerStack, Ok := Registers[instr.Register]
 			if !Ok {
 				registerStack = NewStack()
-				Registers[registerName] = registerStack
+				Registers[instr.Register] = registerStack
 				registerStack.Pointer = 0
 			} else {
 				registerStack.Pointer = 1
@@ -513,23 +792,16 @@ func Interp(r io.Reader) error {
 			RootStack.Push(registerStack.Pop())
 			registerStack.Pointer = 1
 
-		case 'L':
-			// Retrieve from top of register stack
-			registerName, _, readErr := reader.ReadRune()
-			Log("register name: %c", registerName)
-			if readErr != nil {
-				return readErr
-			}
-
-			registerStack, Ok := Registers[registerName]
+		case OpLoadTop:
+			registerStack, Ok := Registers[instr.Register]
 			if !Ok {
 				registerStack = NewStack()
-				Registers[registerName] = registerStack
+				Registers[instr.Register] = registerStack
 			}
 
 			RootStack.Push(registerStack.Pop())
 
-		case 'd':
+		case OpDup:
 			// Duplicate the top item on the stack
 
 			Log("duplicating to stack item")
@@ -542,240 +814,169 @@ func Interp(r io.Reader) error {
 
 			RootStack.Push(b)
 
-		case 'p':
-			// TODO: Needs more re-thinking in the case of non-10 output base
-			// Print the top item in the stack
+		case OpPrint:
 			a := RootStack.Peek()
 			if a.IsNumber {
-				if a.Number.Scale != 0 {
-					var aNum float64 = float64(a.Number.Number) / (10.0 * float64(a.Number.Scale))
-					fmt.Printf("%f\n", aNum)
-				} else {
-					fmt.Printf("%d\n", a.Number.Number)
-				}
+				fmt.Printf("%s\n", formatScaledNumber(a.Number))
 			} else {
 				return fmt.Errorf("can not exeucte p on a command")
 			}
 
-		case 'P':
-			// Pop the top item from the stack & print it as a string
+		case OpPrintPop:
 			a := RootStack.Pop()
 			if a.IsNumber {
-				return fmt.Errorf("P can not execute on a number")
+				// dc's documented behaviour for P on a number: truncate
+				// to an integer and emit its base-256 representation as
+				// raw bytes, most significant byte first.
+				num := a.Number
+				RescaleNumber(&num, 0)
+				os.Stdout.Write(new(big.Int).Abs(num.Number).Bytes())
 			} else {
-				fmt.Printf("%s", string(a.Command))
+				fmt.Printf("%s", a.Command.Source)
 			}
 
-		case 'f':
-			// TODO: Needs more re-thinking in the case of non-10 output base
-			// Print out all of the values on the stack
+		case OpPrintAll:
 			var output string
 			for i := 0; i <= RootStack.Pointer; i++ {
 				a := RootStack.Items[i]
 				if a.IsNumber {
-					if a.Number.Scale != 0 {
-						var aNum float64 = float64(a.Number.Number) / (10.0 * float64(a.Number.Scale))
-						output = fmt.Sprintf("%f", aNum)
-					} else {
-						output = fmt.Sprintf("%d", a.Number.Number)
-					}
+					output = formatScaledNumber(a.Number)
 				} else {
-					output = string(a.Command)
+					output = a.Command.Source
 				}
 				fmt.Printf("%s\n", output)
 			}
 
-		case 'q':
-			// TODO Make this work with the recursive calls to Interp
-			InterpLevel -= 2
-			if InterpLevel < 0 {
-				return nil
-			}
+		case OpQuit:
+			// q exits the current invocation and the one that invoked
+			// it, so a macro can be called in a loop that a single q
+			// breaks all the way out of.
+			popFrames(base, 2)
 
-		case 'Q':
-			// TODO Make this work with the recursive calls to Interp
+		case OpQuitN:
 			dropLevel := RootStack.Pop()
 			if dropLevel.IsNumber {
 				RescaleNumber(&dropLevel.Number, 0)
-				InterpLevel -= int32(dropLevel.Number.Number)
-				if InterpLevel < 0 {
-					return nil
-				}
+				popFrames(base, int(dropLevel.Number.Number.Int64()))
 			} else {
 				return fmt.Errorf("Q can not be implemented with a command stack item")
 			}
 
-		case 'x':
+		case OpExec:
 			cmd := RootStack.Pop()
 			if cmd.IsNumber {
 				return fmt.Errorf("x can not be implemented with a number")
 			}
 
-			sr := strings.NewReader(string(cmd.Command))
-			fb := bufio.NewReader(sr)
-			err := Interp(fb)
-
-			if err != nil {
+			if err := pushFrame(cmd.Command, 0); err != nil {
 				return err
 			}
 
-		case 'X':
+		case OpExecScale:
 			x := RootStack.Pop()
 			if !x.IsNumber {
 				return fmt.Errorf("X can not be implemented with a command stack item")
 			}
 
-			x.Number.Number = int64(x.Number.Scale)
+			x.Number.Number = big.NewInt(int64(x.Number.Scale))
 			x.Number.Scale = 0
 
 			RootStack.Push(x)
 
-		case '<':
-			a := RootStack.Pop()
-			b := RootStack.Pop()
-			if !a.IsNumber || !b.IsNumber {
-				return fmt.Errorf("Expected both items from the stack to be numbers")
-			}
-
-			registerName, _, readErr := reader.ReadRune()
-			Log("register name: %c", registerName)
-			if readErr != nil {
-				return readErr
-			}
-			var aNum float64 = float64(a.Number.Number) / math.Pow(10.0, float64(a.Number.Scale))
-			var bNum float64 = float64(b.Number.Number) / math.Pow(10.0, float64(b.Number.Scale))
-
-			if aNum < bNum {
-				cmd := Registers[registerName].Peek()
-				if cmd.IsNumber {
-					return fmt.Errorf("x can not be implemented with a number")
-				}
-
-				sr := strings.NewReader(string(cmd.Command))
-				fb := bufio.NewReader(sr)
-				err := Interp(fb)
-
-				if err != nil {
-					return err
-				}
-			}
-
-		case '>':
+		case OpLessThan, OpGreaterThan, OpEqual:
 			a := RootStack.Pop()
 			b := RootStack.Pop()
 			if !a.IsNumber || !b.IsNumber {
 				return fmt.Errorf("Expected both items from the stack to be numbers")
 			}
 
-			registerName, _, readErr := reader.ReadRune()
-			Log("register name: %c", registerName)
-			if readErr != nil {
-				return readErr
-			}
-			var aNum float64 = float64(a.Number.Number) / math.Pow(10.0, float64(a.Number.Scale))
-			var bNum float64 = float64(b.Number.Number) / math.Pow(10.0, float64(b.Number.Scale))
-			Log("%v > %v", aNum, bNum)
-
-			if aNum > bNum {
-				cmd := Registers[registerName].Peek()
-				Log("cmd from register: %v", cmd)
-				if cmd.IsNumber {
-					return fmt.Errorf("x can not be implemented with a number")
-				}
+			aNum := a.Number
+			bNum := b.Number
+			targetScale := IntMax(aNum.Scale, bNum.Scale)
+			RescaleNumber(&aNum, targetScale)
+			RescaleNumber(&bNum, targetScale)
 
-				sr := strings.NewReader(string(cmd.Command))
-				fb := bufio.NewReader(sr)
-				err := Interp(fb)
+			cmp := aNum.Number.Cmp(bNum.Number)
+			takeBranch := (instr.Op == OpLessThan && cmp < 0) ||
+				(instr.Op == OpGreaterThan && cmp > 0) ||
+				(instr.Op == OpEqual && cmp == 0)
 
-				if err != nil {
-					return err
+			if takeBranch {
+				registerStack, ok := Registers[instr.Register]
+				if !ok {
+					return &StackUnderflow{Register: instr.Register}
 				}
-			}
 
-		case '=':
-			a := RootStack.Pop()
-			b := RootStack.Pop()
-			if !a.IsNumber || !b.IsNumber {
-				return fmt.Errorf("Expected both items from the stack to be numbers")
-			}
-
-			registerName, _, readErr := reader.ReadRune()
-			Log("register name: %c", registerName)
-			if readErr != nil {
-				return readErr
-			}
-
-			if a.Number.Scale == b.Number.Scale && a.Number.Number == b.Number.Number {
-				cmd := Registers[registerName].Peek()
+				cmd := registerStack.Peek()
 				if cmd.IsNumber {
 					return fmt.Errorf("x can not be implemented with a number")
 				}
 
-				sr := strings.NewReader(string(cmd.Command))
-				fb := bufio.NewReader(sr)
-				err := Interp(fb)
-
-				if err != nil {
+				if err := pushFrame(cmd.Command, instr.Register); err != nil {
 					return err
 				}
 			}
 
-		case '!':
+		case OpShellExec:
 			// Execute a bash command up to the newline
 
-		case 'c':
+		case OpClearStack:
 			RootStack = NewStack()
 
-		case 'i':
+		case OpSetScale:
 			a := RootStack.Pop()
 			if !a.IsNumber {
 				return fmt.Errorf("i can not interpret a command as a scale")
 			}
 			RescaleNumber(&a.Number, 0)
-			InterpScale = int32(a.Number.Number)
+			InterpScale = int32(a.Number.Number.Int64())
 
-		case 'I':
+		case OpPushScale:
 			i := NewItem()
 			i.IsNumber = true
-			i.Number.Number = int64(InterpScale)
+			i.Number.Number = big.NewInt(int64(InterpScale))
 
 			RootStack.Push(i)
 
-		case 'o':
+		case OpSetBase:
 			// In bases larger than 10, each `digit' prints as a group of decimal digits.
 			a := RootStack.Pop()
 			if !a.IsNumber {
 				return fmt.Errorf("o can not interpret a command as a scale")
 			}
 			RescaleNumber(&a.Number, 0)
-			OutBase = a.Number.Number
+			base := a.Number.Number.Int64()
+			if base < 2 {
+				return fmt.Errorf("o: output base must be at least 2, got %d", base)
+			}
+			OutBase = base
 
-		case 'O':
+		case OpPushBase:
 			i := NewItem()
 			i.IsNumber = true
-			i.Number.Number = OutBase
+			i.Number.Number = big.NewInt(OutBase)
 
 			RootStack.Push(i)
 
-		case 'k':
+		case OpSetOutScale:
 			a := RootStack.Pop()
 			if !a.IsNumber {
 				return fmt.Errorf("k can not interpret a command as a scale")
 			}
 			RescaleNumber(&a.Number, 0)
-			OutScale = int32(a.Number.Number)
+			OutScale = int32(a.Number.Number.Int64())
 
-		case 'z':
+		case OpDepth:
 			i := NewItem()
 			i.IsNumber = true
-			i.Number.Number = int64(RootStack.Pointer)
+			i.Number.Number = big.NewInt(int64(RootStack.Pointer))
 
 			RootStack.Push(i)
 
-		case 'Z':
+		case OpLen:
 			// TODO Replace the number on the top of the stack with its length.
 
-		case '?':
+		case OpReadExec:
 			// A line of input is taken from the input source (usually the terminal) and executed.
 			// TODO Figure out how this work when commands from stdin is already being read from by default
 
@@ -785,27 +986,42 @@ func Interp(r io.Reader) error {
 }
 
 func main() {
+	interactive := flag.Bool("i", false, "run an interactive REPL, regardless of whether stdin is a terminal")
+	echo := flag.Bool("e", false, "in REPL mode, print the top of stack after each line")
+	debug := flag.Bool("g", false, "attach an interactive TTY debugger, prompting on stderr before each instruction")
+	flag.Parse()
+
 	// Initialize our memory
 	MemLog = make([]string, MemLogLen)
 	RootStack = NewStack()
 	Registers = make(map[rune]*Stack)
 
-	if len(os.Args) > 1 {
-		Log("opening file: %v", os.Args[1])
-		file, err := os.Open(os.Args[1])
+	if *debug {
+		ActiveDebugger = NewTTYDebugger()
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		Log("opening file: %v", args[0])
+		file, err := os.Open(args[0])
 		if err != nil {
 			LogOut()
 			panic(err.Error())
 		}
 		Log("interpreting file")
-		interpErr := Interp(file)
+		interpErr := Run(file)
 		if interpErr != nil {
 			LogOut()
 			panic(interpErr.Error())
 		}
+	} else if *interactive || isTerminal(os.Stdin) {
+		Log("starting REPL")
+		if err := RunREPL(*echo); err != nil {
+			LogOut()
+			panic(err.Error())
+		}
 	} else {
 		Log("interpreting stdin")
-		interpErr := Interp(os.Stdin)
+		interpErr := Run(os.Stdin)
 		if interpErr != nil {
 			LogOut()
 			panic(interpErr.Error())
@@ -0,0 +1,44 @@
+package main
+
+// Debugger lets external tooling observe or control the dispatch loop,
+// modeled on go-ethereum's vm.Debugger. Interp consults it before every
+// instruction: BreakHook fires when pc is one of BreakPoints(), and
+// StepHook fires on every instruction once the debugger has put
+// execution into single-step mode.
+type Debugger interface {
+	// SetProgram is called whenever the dispatch loop starts executing a
+	// different compiled Program, so the debugger can resolve pc against
+	// the right instruction list.
+	SetProgram(p *Program)
+
+	// BreakHook runs before the instruction at pc when pc is armed as a
+	// breakpoint. Returning false aborts the program.
+	BreakHook(pc int, op Opcode, root *Stack, regs map[rune]*Stack) bool
+
+	// StepHook runs before every instruction while single-stepping is
+	// active. Returning false aborts the program, the same as BreakHook.
+	StepHook(pc int, op Opcode, root *Stack, regs map[rune]*Stack) bool
+
+	// BreakPoints lists the currently armed breakpoint program counters.
+	BreakPoints() []int
+
+	// OnLog receives every message also recorded into MemLog, so
+	// external tools can subscribe to trace events without recompiling
+	// with DebugLog=true.
+	OnLog(msg string)
+}
+
+// ActiveDebugger is consulted by Interp before each instruction. A nil
+// ActiveDebugger (the default) disables all debugger overhead beyond a
+// single nil check.
+var ActiveDebugger Debugger
+
+// armedAt reports whether pc appears among breakpoints.
+func armedAt(breakpoints []int, pc int) bool {
+	for _, bp := range breakpoints {
+		if bp == pc {
+			return true
+		}
+	}
+	return false
+}
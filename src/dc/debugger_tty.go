@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TTYDebugger is a Debugger that drives its break/step prompts over
+// stderr, for use with the dc binary's -g flag.
+type TTYDebugger struct {
+	prog        *Program
+	breakpoints []int
+	stepping    bool
+	in          *bufio.Reader
+}
+
+// NewTTYDebugger returns a TTYDebugger that prompts on and reads its
+// n/s/c/p/bp N/q commands from stderr, rather than stdin: stdin is where
+// a dc program itself can arrive (piped input, `<r`-style reads), so a
+// debugger sharing it would steal bytes the interpreted program expects.
+// It starts in single-step mode so -g always breaks before the first
+// instruction instead of running to completion with no breakpoints
+// armed.
+func NewTTYDebugger() *TTYDebugger {
+	return &TTYDebugger{in: bufio.NewReader(os.Stderr), stepping: true}
+}
+
+func (d *TTYDebugger) SetProgram(p *Program) {
+	d.prog = p
+}
+
+func (d *TTYDebugger) BreakPoints() []int {
+	return d.breakpoints
+}
+
+func (d *TTYDebugger) OnLog(msg string) {}
+
+func (d *TTYDebugger) BreakHook(pc int, op Opcode, root *Stack, regs map[rune]*Stack) bool {
+	fmt.Fprintf(os.Stderr, "breakpoint hit at pc=%d\n", pc)
+	return d.prompt(pc, op, root, regs)
+}
+
+func (d *TTYDebugger) StepHook(pc int, op Opcode, root *Stack, regs map[rune]*Stack) bool {
+	if !d.stepping {
+		return true
+	}
+	return d.prompt(pc, op, root, regs)
+}
+
+// prompt prints the current op and stack contents, then reads commands
+// from stderr until one of them resumes execution. It returns false only
+// when the user asks to quit the program entirely.
+func (d *TTYDebugger) prompt(pc int, op Opcode, root *Stack, regs map[rune]*Stack) bool {
+	for {
+		fmt.Fprintf(os.Stderr, "pc=%d op=%v> ", pc, op)
+		line, err := d.in.ReadString('\n')
+		if err != nil {
+			return true
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "n", "s":
+			d.stepping = true
+			return true
+		case "c":
+			d.stepping = false
+			return true
+		case "p":
+			d.printStack(root, regs)
+		case "bp":
+			if len(fields) < 2 {
+				fmt.Fprintln(os.Stderr, "usage: bp N")
+				continue
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid breakpoint: %v\n", err)
+				continue
+			}
+			d.breakpoints = append(d.breakpoints, n)
+		case "q":
+			return false
+		default:
+			fmt.Fprintf(os.Stderr, "unknown debugger command %q\n", fields[0])
+		}
+	}
+}
+
+// printStack shows the top few root-stack items and the top of every
+// named register's stack.
+func (d *TTYDebugger) printStack(root *Stack, regs map[rune]*Stack) {
+	fmt.Fprintln(os.Stderr, "stack (top first):")
+	for i := root.Pointer; i >= 0 && i > root.Pointer-5; i-- {
+		fmt.Fprintf(os.Stderr, "  %s\n", describeItem(root.Items[i]))
+	}
+
+	for name, s := range regs {
+		if s.Pointer < 0 {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "  register %c: %s\n", name, describeItem(s.Items[s.Pointer]))
+	}
+}
+
+func describeItem(item *StackItem) string {
+	if item.IsNumber {
+		return formatScaledNumber(item.Number)
+	}
+	return "[" + item.Command.Source + "]"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// TestFormatScaledNumberBases is a table-driven check of formatScaledNumber
+// across the output bases dc is expected to render correctly: small
+// single-character bases, hex, and a >16 base that falls back to
+// space-separated decimal groups.
+func TestFormatScaledNumberBases(t *testing.T) {
+	cases := []struct {
+		name  string
+		num   string
+		scale int32
+		base  int64
+		want  string
+	}{
+		{"binary", "10", 0, 2, "1010"},
+		{"octal", "64", 0, 8, "100"},
+		{"decimal with fraction", "3141", 2, 10, "31.41"},
+		{"hex", "255", 0, 16, "FF"},
+		{"hex negative", "-255", 0, 16, "-FF"},
+		{"base 100 grouped", "255", 0, 100, " 02 55"},
+		{"base 100 grouped, wider digits", "65536", 0, 1000, " 065 536"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			OutBase = c.base
+			OutScale = 0
+
+			n, ok := new(big.Int).SetString(c.num, 10)
+			if !ok {
+				t.Fatalf("invalid test fixture %q", c.num)
+			}
+
+			got := formatScaledNumber(ScaledNumber{Number: n, Scale: c.scale})
+			if got != c.want {
+				t.Errorf("formatScaledNumber(%s, scale=%d, base=%d) = %q, want %q", c.num, c.scale, c.base, got, c.want)
+			}
+		})
+	}
+}
+
+// TestFormatScaledNumberOutScale checks that OutScale pads or truncates
+// the printed fraction independently of the number's own Scale.
+func TestFormatScaledNumberOutScale(t *testing.T) {
+	cases := []struct {
+		name     string
+		num      string
+		scale    int32
+		outScale int32
+		want     string
+	}{
+		{"pads an integer", "10", 0, 5, "10.00000"},
+		{"truncates a longer fraction", "3", 3, 1, "0.0"},
+		{"unaffected when zero", "3141", 2, 0, "31.41"},
+	}
+
+	OutBase = 10
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			OutScale = c.outScale
+
+			n, ok := new(big.Int).SetString(c.num, 10)
+			if !ok {
+				t.Fatalf("invalid test fixture %q", c.num)
+			}
+
+			got := formatScaledNumber(ScaledNumber{Number: n, Scale: c.scale})
+			if got != c.want {
+				t.Errorf("formatScaledNumber(%s, scale=%d, OutScale=%d) = %q, want %q", c.num, c.scale, c.outScale, got, c.want)
+			}
+		})
+	}
+	OutScale = 0
+}
+
+// TestPrintPopRawBytes checks that `P` on a number emits its base-256
+// representation rather than erroring.
+func TestPrintPopRawBytes(t *testing.T) {
+	got := runDC(t, "256 65 * 66 +P")
+	want := "AB"
+	if got != want {
+		t.Errorf("runDC(256 65 * 66 +P) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+// TestReadNumberFractionalLiterals checks that the number of digits after
+// the `.` becomes the literal's scale, neither counting the `.` itself
+// nor double-counting the first fractional digit.
+func TestReadNumberFractionalLiterals(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"1.5p", "1.5\n"},
+		{"3.14p", "3.14\n"},
+		{".5p", "0.5\n"},
+		{"10p", "10\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.src, func(t *testing.T) {
+			got := runDC(t, c.src)
+			if got != c.want {
+				t.Errorf("runDC(%q) = %q, want %q", c.src, got, c.want)
+			}
+		})
+	}
+}
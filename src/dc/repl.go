@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/peterh/liner"
+)
+
+// historyFilePath is where REPL line history persists between sessions.
+func historyFilePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".dc_history"
+	}
+	return filepath.Join(home, ".dc_history")
+}
+
+// isTerminal reports whether f is attached to a terminal, so the REPL
+// can auto-enable itself on an interactive stdin.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// bracketDelta returns how many more `[` than `]` appear in s, so the
+// REPL can tell when a multi-line macro is still open.
+func bracketDelta(s string) int {
+	delta := 0
+	for _, r := range s {
+		switch r {
+		case '[':
+			delta++
+		case ']':
+			delta--
+		}
+	}
+	return delta
+}
+
+// RunREPL drives an interactive dc session with line editing and
+// persistent history, via liner. It buffers lines until every `[` has a
+// matching `]`, then compiles and executes the buffered source. An
+// interpreter error is printed rather than fatal, so a mistyped
+// expression doesn't end the session.
+func RunREPL(echo bool) error {
+	term := liner.NewLiner()
+	defer term.Close()
+	term.SetCtrlCAborts(true)
+
+	historyPath := historyFilePath()
+	if f, err := os.Open(historyPath); err == nil {
+		term.ReadHistory(f)
+		f.Close()
+	}
+
+	var source string
+	depth := 0
+
+	for {
+		prompt := "dc> "
+		if depth > 0 {
+			prompt = "... "
+		}
+
+		input, err := term.Prompt(prompt)
+		if err != nil {
+			if err == io.EOF || err == liner.ErrPromptAborted {
+				break
+			}
+			return err
+		}
+
+		term.AppendHistory(input)
+		source += input + "\n"
+		depth += bracketDelta(input)
+
+		if depth > 0 {
+			continue
+		}
+		if depth < 0 {
+			fmt.Fprintln(os.Stderr, "dc: unmatched ']'")
+			source = ""
+			depth = 0
+			continue
+		}
+
+		prog, err := Compile(source)
+		source = ""
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dc: %v\n", err)
+			continue
+		}
+
+		if err := Interp(prog); err != nil {
+			fmt.Fprintf(os.Stderr, "dc: %v\n", err)
+			continue
+		}
+
+		if echo {
+			a := RootStack.Peek()
+			if a.IsNumber {
+				fmt.Println(formatScaledNumber(a.Number))
+			} else {
+				fmt.Println(a.Command.Source)
+			}
+		}
+	}
+
+	if f, err := os.Create(historyPath); err == nil {
+		term.WriteHistory(f)
+		f.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// runDC compiles and interprets src against a fresh interpreter state,
+// capturing everything written to stdout.
+func runDC(t *testing.T, src string) string {
+	t.Helper()
+
+	MemLog = make([]string, MemLogLen)
+	RootStack = NewStack()
+	Registers = make(map[rune]*Stack)
+	InvocationStack = nil
+	InterpScale = 0
+	OutBase = 10
+	OutScale = 0
+
+	prog, err := Compile(src)
+	if err != nil {
+		t.Fatalf("Compile(%q): %v", src, err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	interpErr := Interp(prog)
+
+	w.Close()
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	os.Stdout = stdout
+
+	if interpErr != nil {
+		t.Fatalf("Interp(%q): %v", src, interpErr)
+	}
+
+	return buf.String()
+}
+
+// TestSqrtReferenceValues checks `v` against the values coreutils dc
+// prints for the same scripts.
+func TestSqrtReferenceValues(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"integer truncation at scale 0", "2vp", "1\n"},
+		{"exact perfect square via ^", "2 100^vp", "1125899906842624\n"},
+		{"zero", "0vp", "0\n"},
+		{"one", "1vp", "1\n"},
+		{"perfect square", "144vp", "12\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := runDC(t, c.src)
+			if got != c.want {
+				t.Errorf("runDC(%q) = %q, want %q", c.src, got, c.want)
+			}
+		})
+	}
+}
+
+// TestSqrtNegativeIsRejected checks that v on a negative number returns
+// the typed NegativeSquareRoot error instead of panicking or silently
+// producing a wrong result.
+func TestSqrtNegativeIsRejected(t *testing.T) {
+	RootStack = NewStack()
+	Registers = make(map[rune]*Stack)
+	InvocationStack = nil
+	InterpScale = 0
+
+	prog, err := Compile("_5v")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	err = Interp(prog)
+	if _, ok := err.(*NegativeSquareRoot); !ok {
+		t.Fatalf("Interp(_5v) error = %v, want *NegativeSquareRoot", err)
+	}
+}